@@ -3,16 +3,23 @@ package main
 import (
 	"context"
 	"flag"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/aojea/kloudflarelb/pkg/cloudflareapi"
 	"github.com/aojea/kloudflarelb/pkg/cloudflared"
 	"github.com/aojea/kloudflarelb/pkg/config"
 	"github.com/aojea/kloudflarelb/pkg/loadbalancer"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/util/homedir"
 	"k8s.io/klog/v2"
 )
@@ -26,14 +33,57 @@ func main() {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
 
+	var cfAPIToken, cfZoneID string
+
 	c := config.Config{}
 	flag.StringVar(&c.Domain, "domain", "", "domain associated to the tunnel")
 	flag.StringVar(&c.TunnelID, "tunnelID", "", "cloudlfared tunnel <name/uuid>")
 	flag.StringVar(&c.CredentialsFile, "credentials-file", "", "cloudflare credentials file")
+	flag.StringVar(&cfAPIToken, "cloudflare-api-token", "", "Cloudflare API token used to manage DNS records and tunnel routes; DNS management is skipped if empty")
+	flag.StringVar(&cfZoneID, "cloudflare-zone-id", "", "Cloudflare zone ID the managed DNS records belong to")
+
+	var leaderElect bool
+	var leaseDuration, renewDeadline, retryPeriod time.Duration
+	var leaseNamespace, leaseName string
+	flag.BoolVar(&leaderElect, "leader-elect", false, "use leader election so only one replica runs the controller and the cloudflared tunnel at a time")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "duration non-leader candidates wait before forcing acquisition of the Lease")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "duration the leader retries renewing the Lease before giving it up")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "duration candidates wait between attempts to acquire the Lease")
+	flag.StringVar(&leaseNamespace, "leader-elect-resource-namespace", "default", "namespace of the Lease object used for leader election")
+	flag.StringVar(&leaseName, "leader-elect-resource-name", "kloudflarelb-leader", "name of the Lease object used for leader election")
+
+	var metricsBindAddress string
+	var concurrentSyncs int
+	var rateLimiterQPS float64
+	var rateLimiterBurst int
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", "", "address to serve Prometheus metrics on, e.g. :8080; metrics are disabled if empty")
+	flag.IntVar(&concurrentSyncs, "concurrent-syncs", 1, "number of services to reconcile concurrently")
+	flag.Float64Var(&rateLimiterQPS, "rate-limiter-qps", 10, "steady-state QPS of the token bucket limiting requeues of the service workqueue")
+	flag.IntVar(&rateLimiterBurst, "rate-limiter-burst", 100, "burst size of the token bucket limiting requeues of the service workqueue")
 
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	if metricsBindAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			klog.Infof("Serving metrics on %s", metricsBindAddress)
+			if err := http.ListenAndServe(metricsBindAddress, mux); err != nil {
+				klog.Errorf("metrics server exited: %v", err)
+			}
+		}()
+	}
+
+	var dns cloudflareapi.LoadBalancer
+	if cfAPIToken != "" {
+		cfClient, err := cloudflareapi.New(cfAPIToken, cfZoneID, c.TunnelID)
+		if err != nil {
+			panic(err.Error())
+		}
+		dns = cfClient
+	}
+
 	// use the current context in kubeconfig
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -46,27 +96,69 @@ func main() {
 		panic(err.Error())
 	}
 
+	daemon := cloudflared.NewDaemon(cloudflared.DefaultConfigPath)
+
+	rateLimiter := loadbalancer.NewRateLimiter(rateLimiterQPS, rateLimiterBurst)
+
 	informer := informers.NewSharedInformerFactory(clientset, 0)
 	lbController := loadbalancer.NewController(
 		c,
 		clientset,
 		informer.Core().V1().Services(),
+		dns,
+		daemon,
+		rateLimiter,
 	)
 
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	klog.Info("Starting informer")
-	informer.Start(ctx.Done())
-	go lbController.Run(1, ctx.Done())
+	// run starts the informer, the controller workers and the cloudflared
+	// subprocess, and blocks until leCtx is done. With leader election
+	// enabled it is only ever called while holding the Lease, and
+	// leCtx is cancelled the moment the Lease is lost so the workers and
+	// cloudflared are stopped before another replica takes over.
+	run := func(leCtx context.Context) {
+		klog.Info("Starting informer")
+		informer.Start(leCtx.Done())
+		go lbController.Run(concurrentSyncs, leCtx.Done())
+
+		klog.Info("Starting cloudflared daemon")
+		daemon.Run(leCtx)
+	}
 
-	cloudflare := cloudflared.NewFromConfig(c)
-	klog.Info("Starting cloudflared daemon")
-	err = cloudflare.Run(ctx)
+	if !leaderElect {
+		run(ctx)
+		os.Exit(0)
+	}
+
+	id, err := os.Hostname()
 	if err != nil {
-		klog.Errorf("Error running cloudflared: %v", err)
-		os.Exit(1)
+		panic(err.Error())
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: leaseName, Namespace: leaseNamespace},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: id},
 	}
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				klog.Info("Lost leadership, stopping controller and cloudflared")
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("New leader elected: %s", identity)
+				}
+			},
+		},
+	})
 	os.Exit(0)
 }
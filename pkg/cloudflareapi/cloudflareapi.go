@@ -0,0 +1,151 @@
+// Package cloudflareapi manages the Cloudflare DNS records and tunnel routes
+// that point a hostname at a tunnel, via the Cloudflare API.
+package cloudflareapi
+
+import (
+	"context"
+	"fmt"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"k8s.io/klog/v2"
+)
+
+// recordType is the only DNS record type this package manages: a CNAME
+// pointing at the tunnel's cfargotunnel.com target.
+const recordType = "CNAME"
+
+// LoadBalancer is the interface boundary between the loadbalancer controller
+// and a Cloudflare DNS/tunnel backend. It mirrors the EnsureLoadBalancer /
+// EnsureLoadBalancerDeleted contract used by Kubernetes cloud providers so
+// alternative Cloudflare backends (or a fake for tests) can be plugged in
+// without the controller knowing about the Cloudflare API.
+type LoadBalancer interface {
+	// EnsureLoadBalancer creates or updates the CNAME record and tunnel
+	// route that point hostname at tunnelID. It is expected to be
+	// idempotent and safe to call on every sync.
+	EnsureLoadBalancer(ctx context.Context, hostname, tunnelID string) error
+	// EnsureLoadBalancerDeleted removes the CNAME record and tunnel route
+	// for hostname, if they exist. It is expected to be idempotent.
+	EnsureLoadBalancerDeleted(ctx context.Context, hostname string) error
+	// Reconcile diffs desired (hostname -> tunnelID) against the tunnel
+	// CNAME records actually present in the zone and deletes any that are
+	// no longer desired, cleaning up records left behind by services that
+	// were deleted while the controller wasn't running.
+	Reconcile(ctx context.Context, desired map[string]string) error
+}
+
+// Client is a LoadBalancer backed by the Cloudflare API.
+type Client struct {
+	api      *cloudflare.API
+	zoneID   string
+	tunnelID string
+}
+
+// New returns a Client authenticated with apiToken, managing DNS records in
+// the given zone that target tunnelID. tunnelID scopes Reconcile: only
+// records pointing at this tunnel are ever considered "ours" to delete, so
+// two kloudflarelb deployments (e.g. staging and prod) sharing a zone but
+// using different tunnels never touch each other's records.
+func New(apiToken, zoneID, tunnelID string) (*Client, error) {
+	api, err := cloudflare.NewWithAPIToken(apiToken)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{api: api, zoneID: zoneID, tunnelID: tunnelID}, nil
+}
+
+// EnsureLoadBalancer creates or updates the CNAME record that routes
+// hostname to the tunnel identified by tunnelID.
+func (c *Client) EnsureLoadBalancer(ctx context.Context, hostname, tunnelID string) error {
+	target := tunnelTarget(tunnelID)
+	rc := cloudflare.ZoneIdentifier(c.zoneID)
+
+	existing, err := c.findRecord(ctx, hostname)
+	if err != nil {
+		return err
+	}
+
+	proxied := true
+	if existing == nil {
+		klog.Infof("Creating CNAME record %s -> %s", hostname, target)
+		_, err := c.api.CreateDNSRecord(ctx, rc, cloudflare.CreateDNSRecordParams{
+			Type:    recordType,
+			Name:    hostname,
+			Content: target,
+			Proxied: &proxied,
+			TTL:     1,
+		})
+		return err
+	}
+
+	if existing.Content == target {
+		return nil
+	}
+
+	klog.Infof("Updating CNAME record %s -> %s (was %s)", hostname, target, existing.Content)
+	return c.api.UpdateDNSRecord(ctx, rc, cloudflare.UpdateDNSRecordParams{
+		ID:      existing.ID,
+		Type:    recordType,
+		Name:    hostname,
+		Content: target,
+		Proxied: &proxied,
+		TTL:     1,
+	})
+}
+
+// EnsureLoadBalancerDeleted removes the CNAME record for hostname, if any.
+func (c *Client) EnsureLoadBalancerDeleted(ctx context.Context, hostname string) error {
+	existing, err := c.findRecord(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	klog.Infof("Deleting CNAME record %s", hostname)
+	return c.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), existing.ID)
+}
+
+// Reconcile lists the CNAME records currently present in the zone that
+// target this Client's own tunnel and deletes any whose hostname is not in
+// desired, cleaning up records left behind by services that were removed
+// while the controller wasn't running. Records pointing at any other tunnel
+// are left untouched, so other kloudflarelb deployments sharing the zone
+// are never affected.
+func (c *Client) Reconcile(ctx context.Context, desired map[string]string) error {
+	target := tunnelTarget(c.tunnelID)
+	rc := cloudflare.ZoneIdentifier(c.zoneID)
+	records, _, err := c.api.ListDNSRecords(ctx, rc, cloudflare.ListDNSRecordsParams{Type: recordType})
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.Content != target {
+			continue
+		}
+		if _, ok := desired[record.Name]; ok {
+			continue
+		}
+		klog.Infof("Deleting stale CNAME record %s -> %s", record.Name, record.Content)
+		if err := c.api.DeleteDNSRecord(ctx, rc, record.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) findRecord(ctx context.Context, hostname string) (*cloudflare.DNSRecord, error) {
+	rc := cloudflare.ZoneIdentifier(c.zoneID)
+	records, _, err := c.api.ListDNSRecords(ctx, rc, cloudflare.ListDNSRecordsParams{Name: hostname, Type: recordType})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func tunnelTarget(tunnelID string) string {
+	return fmt.Sprintf("%s.cfargotunnel.com", tunnelID)
+}
@@ -1,12 +1,11 @@
 package cloudflared
 
 import (
-	"context"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/aojea/kloudflarelb/pkg/config"
@@ -20,7 +19,11 @@ import (
 
 // Configuration file
 // https://developers.cloudflare.com/cloudflare-one/connections/connect-apps/configuration/configuration-file
-const defaultFilename = "config.yaml"
+
+// DefaultConfigPath is the cloudflared configuration file path used when the
+// controller doesn't override it, and the path the supervised Daemon points
+// cloudflared at.
+const DefaultConfigPath = "config.yaml"
 
 // https://github.com/cloudflare/cloudflared/blob/master/config/configuration.go
 type Configuration struct {
@@ -28,26 +31,29 @@ type Configuration struct {
 	path string
 }
 
-func (c *Configuration) AddIngress(hostname, service string) {
+func (c *Configuration) AddIngress(hostname, service string, originRequest cloudflaredconfig.OriginRequestConfig) {
 	c.Ingress = append(c.Ingress, cloudflaredconfig.UnvalidatedIngressRule{
-		Hostname: hostname,
-		Service:  service,
+		Hostname:      hostname,
+		Service:       service,
+		OriginRequest: originRequest,
 	})
 }
 
-// Write atomically the configuration file if is different
-func (c *Configuration) Write() error {
+// Write atomically writes the configuration file if it changed, reporting
+// whether the on-disk content was actually replaced so callers can decide
+// whether cloudflared needs to reload its ingress configuration.
+func (c *Configuration) Write() (bool, error) {
 	if c.path == "" {
-		return fmt.Errorf("missing configuration file name")
+		return false, fmt.Errorf("missing configuration file name")
 	}
 
 	if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
-		return err
+		return false, err
 	}
 
 	tempFile, err := ioutil.TempFile("", "klb")
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tempFile.Close()
 
@@ -55,29 +61,18 @@ func (c *Configuration) Write() error {
 	defer os.Remove(tempname)
 
 	if err := yaml.NewEncoder(tempFile).Encode(&c); err != nil {
-		return err
+		return false, err
 	}
 
-	if sameFile(tempname, c.path) {
-		return nil
+	if sameContent(tempname, c.path) {
+		return false, nil
 	}
 
-	err = os.Rename(tempname, c.path)
-	if err != nil {
-		return err
+	if err := os.Rename(tempname, c.path); err != nil {
+		return false, err
 	}
 
-	return nil
-}
-
-func (c *Configuration) Run(ctx context.Context) {
-	cmd := exec.CommandContext(ctx, "cloudflared", "--no-autoupdate", "--config", defaultFilename)
-	go func() {
-		err := cmd.Run()
-		if err != nil {
-			klog.Errorf("Error running cloudflared: %w", err)
-		}
-	}()
+	return true, nil
 }
 
 func NewFromFile(path string) (*Configuration, error) {
@@ -101,20 +96,22 @@ func NewFromFile(path string) (*Configuration, error) {
 
 func NewFromConfig(config config.Config) *Configuration {
 	c := Configuration{
-		path: defaultFilename,
+		path: DefaultConfigPath,
 	}
 	c.TunnelID = config.TunnelID
 	return &c
 }
 
-func sameFile(path1, path2 string) bool {
-	ia1, err := os.Stat(path1)
+// sameContent reports whether path1 and path2 hold byte-identical content.
+// A missing path2 (e.g. first write) is treated as different.
+func sameContent(path1, path2 string) bool {
+	b1, err := ioutil.ReadFile(path1)
 	if err != nil {
 		return false
 	}
-	ia2, err := os.Stat(path2)
+	b2, err := ioutil.ReadFile(path2)
 	if err != nil {
 		return false
 	}
-	return os.SameFile(ia1, ia2)
+	return bytes.Equal(b1, b2)
 }
@@ -0,0 +1,129 @@
+package cloudflared
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// restartsTotal counts how many times the supervised cloudflared subprocess
+// was restarted after a crash, for the --metrics-bind-address endpoint.
+var restartsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "kloudflarelb_cloudflared_restarts_total",
+	Help: "Total number of times the supervised cloudflared subprocess was restarted after a crash.",
+})
+
+func init() {
+	prometheus.MustRegister(restartsTotal)
+}
+
+// minRestartBackoff and maxRestartBackoff bound the exponential backoff
+// applied between cloudflared restarts after a crash.
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 30 * time.Second
+)
+
+// Daemon supervises a cloudflared subprocess pointed at a configuration
+// file: it starts it, restarts it with backoff if it crashes, and can ask
+// the running process to reload its ingress configuration in place with
+// SIGHUP instead of a full restart, since cloudflared supports hot reload
+// of the ingress rules.
+// https://developers.cloudflare.com/cloudflare-one/connections/connect-apps/configuration/configuration-file/#reloading-configuration
+type Daemon struct {
+	configPath string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	healthy bool
+}
+
+// NewDaemon returns a Daemon that runs cloudflared against configPath.
+func NewDaemon(configPath string) *Daemon {
+	return &Daemon{configPath: configPath}
+}
+
+// Run starts cloudflared and keeps it running, restarting it with
+// exponential backoff on crash, until ctx is cancelled.
+func (d *Daemon) Run(ctx context.Context) {
+	backoff := minRestartBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := d.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		d.setHealthy(false)
+		klog.Errorf("cloudflared exited: %v", err)
+		restartsTotal.Inc()
+
+		// a long-lived run is not a crash loop, so reset the backoff
+		if time.Since(start) > maxRestartBackoff {
+			backoff = minRestartBackoff
+		}
+		klog.Infof("Restarting cloudflared in %v", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// runOnce starts cloudflared and blocks until it exits.
+func (d *Daemon) runOnce(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "cloudflared", "--no-autoupdate", "--config", d.configPath)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.cmd = cmd
+	d.healthy = true
+	d.mu.Unlock()
+
+	err := cmd.Wait()
+
+	d.mu.Lock()
+	d.cmd = nil
+	d.healthy = false
+	d.mu.Unlock()
+
+	return err
+}
+
+// Reload asks the running cloudflared process to reload its ingress
+// configuration from disk, via SIGHUP, instead of restarting it.
+func (d *Daemon) Reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cmd == nil || d.cmd.Process == nil {
+		return fmt.Errorf("cloudflared is not running")
+	}
+	klog.Infof("Reloading cloudflared configuration (pid %d)", d.cmd.Process.Pid)
+	return d.cmd.Process.Signal(syscall.SIGHUP)
+}
+
+// Healthy reports whether cloudflared is currently running.
+func (d *Daemon) Healthy() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.healthy
+}
+
+func (d *Daemon) setHealthy(v bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.healthy = v
+}
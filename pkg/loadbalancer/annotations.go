@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cloudflaredconfig "github.com/cloudflare/cloudflared/config"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Annotations that let a Service customize how its Cloudflare ingress is
+// generated, beyond the defaults derived from the Service object itself.
+const (
+	// AnnotationHostname overrides the generated
+	// <service>-[<port>-]<namespace>[.<domain>] hostname with a fully
+	// qualified hostname supplied by the user. Unnamed ports use it as-is;
+	// named ports use <port>.<hostname>.
+	AnnotationHostname = "kloudflarelb.io/hostname"
+	// AnnotationTunnelID requests that the service's DNS CNAME record
+	// point at a specific tunnel. The controller only ever runs a single
+	// cloudflared daemon, against c.config.TunnelID, so a value other than
+	// c.config.TunnelID is ignored (with a Warning Event) rather than
+	// honored: routing to a tunnel this controller doesn't serve ingress
+	// for would break the service instead of doing nothing. Routing
+	// through more than one tunnel at once isn't supported yet; this
+	// annotation only has an effect once it is.
+	AnnotationTunnelID = "kloudflarelb.io/tunnel-id"
+	// AnnotationAccessPolicy protects the ingress with a Cloudflare
+	// Access application, restricted to the named Access team.
+	AnnotationAccessPolicy = "kloudflarelb.io/access-policy"
+	// AnnotationOriginRequest is a JSON object overriding cloudflared's
+	// per-ingress originRequest block, e.g.
+	// {"connectTimeout":"10s","noTLSVerify":true,"httpHostHeader":"foo"}.
+	AnnotationOriginRequest = "kloudflarelb.io/origin-request"
+	// AnnotationProtocol overrides the origin scheme (http, https, tcp,
+	// ssh, rdp) that would otherwise be inferred from the port name.
+	AnnotationProtocol = "kloudflarelb.io/protocol"
+)
+
+// hostnameOverride returns the hostname for portName derived from
+// AnnotationHostname, if the service set it: the annotation value itself for
+// an unnamed port, or <portName>.<value> for a named one.
+func hostnameOverride(service *v1.Service, portName string) (string, bool) {
+	host := service.Annotations[AnnotationHostname]
+	if host == "" {
+		return "", false
+	}
+	if portName == "" {
+		return host, true
+	}
+	return portName + "." + host, true
+}
+
+// tunnelIDOverride returns the tunnel ID the service requested via
+// AnnotationTunnelID, falling back to def when it isn't set.
+func tunnelIDOverride(service *v1.Service, def string) string {
+	if id := service.Annotations[AnnotationTunnelID]; id != "" {
+		return id
+	}
+	return def
+}
+
+// protocolOverride returns the origin scheme requested via
+// AnnotationProtocol, and whether the service set it.
+func protocolOverride(service *v1.Service) (string, bool) {
+	proto := service.Annotations[AnnotationProtocol]
+	return proto, proto != ""
+}
+
+// originRequestOverride is the subset of cloudflared's OriginRequestConfig
+// that can be set through AnnotationOriginRequest.
+type originRequestOverride struct {
+	ConnectTimeout *string `json:"connectTimeout,omitempty"`
+	NoTLSVerify    *bool   `json:"noTLSVerify,omitempty"`
+	HTTPHostHeader *string `json:"httpHostHeader,omitempty"`
+}
+
+// originRequestConfig builds the cloudflared OriginRequestConfig for a
+// service from its AnnotationOriginRequest and AnnotationAccessPolicy
+// annotations.
+func originRequestConfig(service *v1.Service) (cloudflaredconfig.OriginRequestConfig, error) {
+	var out cloudflaredconfig.OriginRequestConfig
+
+	if raw := service.Annotations[AnnotationOriginRequest]; raw != "" {
+		var override originRequestOverride
+		if err := json.Unmarshal([]byte(raw), &override); err != nil {
+			return out, fmt.Errorf("invalid %s annotation: %w", AnnotationOriginRequest, err)
+		}
+		if override.ConnectTimeout != nil {
+			d, err := time.ParseDuration(*override.ConnectTimeout)
+			if err != nil {
+				return out, fmt.Errorf("invalid connectTimeout in %s annotation: %w", AnnotationOriginRequest, err)
+			}
+			out.ConnectTimeout = &cloudflaredconfig.CustomDuration{Duration: d}
+		}
+		out.NoTLSVerify = override.NoTLSVerify
+		out.HTTPHostHeader = override.HTTPHostHeader
+	}
+
+	if team := service.Annotations[AnnotationAccessPolicy]; team != "" {
+		out.Access = &cloudflaredconfig.AccessConfig{Required: true, TeamName: team}
+	}
+
+	return out, nil
+}
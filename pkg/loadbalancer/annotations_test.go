@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHostnameOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		portName   string
+		wantHost   string
+		wantOK     bool
+	}{
+		{"no annotation", "", "", "", false},
+		{"no annotation, named port", "", "https", "", false},
+		{"unnamed port uses the hostname as-is", "lb.example.com", "", "lb.example.com", true},
+		{"named port is prefixed", "lb.example.com", "https", "https.lb.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{}
+			if tt.annotation != "" {
+				service.Annotations = map[string]string{AnnotationHostname: tt.annotation}
+			}
+			host, ok := hostnameOverride(service, tt.portName)
+			if host != tt.wantHost || ok != tt.wantOK {
+				t.Errorf("hostnameOverride() = (%q, %v), want (%q, %v)", host, ok, tt.wantHost, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTunnelIDOverride(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AnnotationTunnelID: "other-tunnel"}},
+	}
+	if got := tunnelIDOverride(service, "default-tunnel"); got != "other-tunnel" {
+		t.Errorf("tunnelIDOverride() = %q, want %q", got, "other-tunnel")
+	}
+	if got := tunnelIDOverride(&v1.Service{}, "default-tunnel"); got != "default-tunnel" {
+		t.Errorf("tunnelIDOverride() with no annotation = %q, want %q", got, "default-tunnel")
+	}
+}
+
+func TestOriginRequestConfig(t *testing.T) {
+	t.Run("no annotations", func(t *testing.T) {
+		out, err := originRequestConfig(&v1.Service{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out.ConnectTimeout != nil || out.NoTLSVerify != nil || out.Access != nil {
+			t.Errorf("expected a zero-value OriginRequestConfig, got %+v", out)
+		}
+	})
+
+	t.Run("valid origin-request annotation", func(t *testing.T) {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			AnnotationOriginRequest: `{"connectTimeout":"10s","noTLSVerify":true,"httpHostHeader":"foo.internal"}`,
+		}}}
+		out, err := originRequestConfig(service)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out.ConnectTimeout == nil || out.ConnectTimeout.Duration.String() != "10s" {
+			t.Errorf("ConnectTimeout = %v, want 10s", out.ConnectTimeout)
+		}
+		if out.NoTLSVerify == nil || !*out.NoTLSVerify {
+			t.Errorf("NoTLSVerify = %v, want true", out.NoTLSVerify)
+		}
+		if out.HTTPHostHeader == nil || *out.HTTPHostHeader != "foo.internal" {
+			t.Errorf("HTTPHostHeader = %v, want foo.internal", out.HTTPHostHeader)
+		}
+	})
+
+	t.Run("malformed JSON is rejected", func(t *testing.T) {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			AnnotationOriginRequest: `{not json`,
+		}}}
+		if _, err := originRequestConfig(service); err == nil {
+			t.Error("expected an error for malformed JSON, got nil")
+		}
+	})
+
+	t.Run("malformed connectTimeout is rejected", func(t *testing.T) {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			AnnotationOriginRequest: `{"connectTimeout":"not-a-duration"}`,
+		}}}
+		if _, err := originRequestConfig(service); err == nil {
+			t.Error("expected an error for an invalid connectTimeout, got nil")
+		}
+	})
+
+	t.Run("access-policy sets a required Access config", func(t *testing.T) {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			AnnotationAccessPolicy: "my-team",
+		}}}
+		out, err := originRequestConfig(service)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out.Access == nil || !out.Access.Required || out.Access.TeamName != "my-team" {
+			t.Errorf("Access = %+v, want required access for my-team", out.Access)
+		}
+	})
+}
@@ -5,21 +5,30 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/aojea/kloudflarelb/pkg/cloudflareapi"
 	"github.com/aojea/kloudflarelb/pkg/cloudflared"
 	"github.com/aojea/kloudflarelb/pkg/config"
 
+	cloudflaredconfig "github.com/cloudflare/cloudflared/config"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
@@ -27,6 +36,24 @@ import (
 const (
 	controllerName = "kcloudflare-lb-controller"
 	maxRetries     = 12
+	// dnsReconcilePeriod is how often the tracked hostnames are diffed
+	// against the Cloudflare zone to remove stale CNAME records.
+	dnsReconcilePeriod = 5 * time.Minute
+	// configKey is the single item enqueued in configQueue to debounce
+	// config.yaml writes: repeated syncs within configDebounce of each
+	// other collapse into a single rewrite.
+	configKey      = "config"
+	configDebounce = 2 * time.Second
+	// finalizerName blocks a LoadBalancer Service's deletion until
+	// syncServices has torn down its Cloudflare ingress rule and DNS
+	// record, mirroring the upstream Kubernetes service controller.
+	finalizerName = "kloudflarelb.io/finalizer"
+
+	// Status.Conditions types published on the Service, mirroring the
+	// event Reasons reported through the recorder.
+	conditionTunnelReady        = "TunnelReady"
+	conditionDNSReady           = "DNSReady"
+	conditionCloudflaredHealthy = "CloudflaredHealthy"
 )
 
 // Controller implements a loadbalancer controller that associates a Cloudflare tunnel
@@ -51,19 +78,64 @@ type Controller struct {
 	// track services and associated tunnels
 	mu             sync.Mutex
 	serviceTracker map[string]ingress
+
+	// initialSyncMu protects pendingInitialSync, the set of service keys
+	// seen in the informer cache's initial list that haven't completed a
+	// sync yet. reconcileDNS refuses to run while it is non-empty: until
+	// every pre-existing service has synced at least once, serviceTracker
+	// is known to be an incomplete view of what should exist, and a
+	// reconcile pass would delete live records as "stale". A transiently
+	// empty workqueue is not a safe proxy for this, since a service can sit
+	// in per-item rate-limited backoff (up to 1000s) between requeues.
+	initialSyncMu      sync.Mutex
+	pendingInitialSync map[string]struct{}
+
+	// dns manages the Cloudflare CNAME records and tunnel routes for the
+	// tracked hostnames. It is nil when the controller was not given API
+	// credentials, in which case the operator is expected to create the
+	// DNS records manually.
+	dns cloudflareapi.LoadBalancer
+
+	// daemon supervises the cloudflared subprocess that reads config.yaml.
+	// It is nil when the controller only writes the file for an
+	// externally managed cloudflared, e.g. a sidecar container.
+	daemon *cloudflared.Daemon
+	// configQueue debounces config.yaml rewrites: syncServices enqueues
+	// configKey after every successful sync instead of calling
+	// writeConfig directly.
+	configQueue workqueue.DelayingInterface
+
+	// recorder emits the TunnelReady/DNSReady/CloudflaredHealthy Events
+	// that report reconciliation outcomes on the Service object itself.
+	recorder record.EventRecorder
 }
 
 func NewController(
 	config config.Config,
 	client clientset.Interface,
-	serviceInformer coreinformers.ServiceInformer) *Controller {
+	serviceInformer coreinformers.ServiceInformer,
+	dns cloudflareapi.LoadBalancer,
+	daemon *cloudflared.Daemon,
+	rateLimiter workqueue.RateLimiter) *Controller {
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: controllerName})
+
+	if rateLimiter == nil {
+		rateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
 
 	c := &Controller{
 		config:           config,
 		client:           client,
-		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName),
+		queue:            workqueue.NewNamedRateLimitingQueue(rateLimiter, controllerName),
 		workerLoopPeriod: time.Second,
 		serviceTracker:   map[string]ingress{},
+		dns:              dns,
+		daemon:           daemon,
+		configQueue:      workqueue.NewNamedDelayingQueue(controllerName + "-config"),
+		recorder:         recorder,
 	}
 	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    c.onServiceAdd,
@@ -75,11 +147,26 @@ func NewController(
 	return c
 }
 
+// NewRateLimiter returns a workqueue rate limiter combining per-item
+// exponential backoff with a token bucket of qps (up to burst at once).
+// Like any workqueue rate limiter it only delays keys requeued through
+// AddRateLimited, i.e. retries of failed syncs; keys added by the informer
+// event handlers via Add are not limited by it.
+// This mirrors workqueue.DefaultControllerRateLimiter, except qps/burst are
+// caller-configurable instead of fixed at 10/100.
+func NewRateLimiter(qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, 1000*time.Second),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
 // Run will not return until stopCh is closed. workers determines how many
 // endpoints will be handled in parallel.
 func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.queue.ShutDown()
+	defer c.configQueue.ShutDown()
 
 	klog.Infof("Starting controller %s", controllerName)
 	defer klog.Infof("Shutting down controller %s", controllerName)
@@ -90,16 +177,46 @@ func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
 		return fmt.Errorf("error syncing cache")
 	}
 
+	// Snapshot the services already in the synced cache before starting
+	// any workers, so reconcileDNS can tell whether they have all synced
+	// at least once yet.
+	c.seedInitialSync()
+
+	// Periodically reconcile the Cloudflare DNS records against the
+	// tracked services, cleaning up CNAMEs left behind by services that
+	// were deleted while the controller wasn't running.
+	if c.dns != nil {
+		go wait.Until(c.reconcileDNS, dnsReconcilePeriod, stopCh)
+	}
+
+	// Keep the queue depth gauge fresh for /metrics.
+	go wait.Until(func() { queueDepth.Set(float64(c.queue.Len())) }, time.Second, stopCh)
+
 	// Start the workers after the repair loop to avoid races
 	klog.Info("Starting workers")
 	for i := 0; i < workers; i++ {
 		go wait.Until(c.worker, c.workerLoopPeriod, stopCh)
 	}
+	go c.configWorker()
 
 	<-stopCh
 	return nil
 }
 
+// configWorker debounces config.yaml rewrites: it blocks on configQueue,
+// which syncServices feeds with a delay, so bursts of service changes
+// collapse into a single writeConfig call and cloudflared reload.
+func (c *Controller) configWorker() {
+	for {
+		item, quit := c.configQueue.Get()
+		if quit {
+			return
+		}
+		c.writeConfig()
+		c.configQueue.Done(item)
+	}
+}
+
 // worker runs a worker thread that just dequeues items, processes them, and
 // marks them done. You may run as many of these in parallel as you wish; the
 // workqueue guarantees that they will not end up processing the same service
@@ -125,6 +242,7 @@ func (c *Controller) processNextWorkItem() bool {
 func (c *Controller) handleErr(err error, key interface{}) {
 	if err == nil {
 		c.queue.Forget(key)
+		c.markInitialSyncDone(key.(string))
 		return
 	}
 
@@ -135,12 +253,16 @@ func (c *Controller) handleErr(err error, key interface{}) {
 
 	if c.queue.NumRequeues(key) < maxRetries {
 		klog.V(2).InfoS("Error syncing service, retrying", "service", klog.KRef(ns, name), "err", err)
+		reconcileRetriesTotal.Inc()
 		c.queue.AddRateLimited(key)
 		return
 	}
 
 	klog.Warningf("Dropping service %q out of the queue: %v", key, err)
 	c.queue.Forget(key)
+	// give up on ever syncing this key: counting it as "pending" forever
+	// would permanently block reconcileDNS.
+	c.markInitialSyncDone(key.(string))
 	utilruntime.HandleError(err)
 }
 
@@ -153,6 +275,7 @@ func (c *Controller) syncServices(key string) error {
 	klog.Infof("Processing sync for service %s on namespace %s ", name, namespace)
 
 	defer func() {
+		reconcileDuration.Observe(time.Since(startTime).Seconds())
 		klog.V(4).Infof("Finished syncing service %s on namespace %s : %v", name, namespace, time.Since(startTime))
 	}()
 
@@ -163,56 +286,207 @@ func (c *Controller) syncServices(key string) error {
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	// service no longer exist or is no longer type loadbalancer
-	// release the tunnel if it has one associated and stop tracking the service
-	if err != nil || service.Spec.Type != v1.ServiceTypeLoadBalancer {
-		// return if we were not tracking this service
-		ingress := c.getServiceIngress(key)
-		if ingress.hostname == "" || ingress.service == "" {
+	notFound := apierrors.IsNotFound(err)
+	if err == nil {
+		// never mutate the object returned by the lister: it is the
+		// shared informer cache object, and every path below writes to
+		// it (status, finalizers) before sending it back to the API
+		// server. This also matters once --concurrent-syncs > 1 lets
+		// more than one worker read the same cached object at once.
+		service = service.DeepCopy()
+	}
+	deleting := err == nil && !service.DeletionTimestamp.IsZero()
+	notLoadBalancer := err == nil && service.Spec.Type != v1.ServiceTypeLoadBalancer
+
+	// service no longer exists, is being deleted, or is no longer type
+	// LoadBalancer: release the tunnel(s) it had associated per port and
+	// stop tracking the service. A LoadBalancer service being deleted
+	// keeps its finalizer (and so stays visible here) until this cleanup
+	// has run, so the Cloudflare ingress rule and DNS record are never
+	// leaked even if the controller crashes mid-deletion.
+	if notFound || deleting || notLoadBalancer {
+		ingresses := c.getServiceIngresses(key)
+		if len(ingresses) == 0 {
+			if deleting && hasFinalizer(service) {
+				return c.removeFinalizer(service)
+			}
 			return nil
 		}
-		// clear the service status if the service has mutated
+		// clear the service status if the service has mutated rather than disappeared
 		if err == nil {
 			service.Status.LoadBalancer = v1.LoadBalancerStatus{}
-			_, errUpdate := c.client.CoreV1().Services(namespace).UpdateStatus(context.TODO(), service, metav1.UpdateOptions{})
+			setCondition(service, conditionTunnelReady, metav1.ConditionFalse, "Released", fmt.Sprintf("Releasing Cloudflare ingress for %s", name))
+			if c.dns != nil {
+				setCondition(service, conditionDNSReady, metav1.ConditionFalse, "Released", "CNAME records removed")
+			}
+			updated, errUpdate := c.client.CoreV1().Services(namespace).UpdateStatus(context.TODO(), service, metav1.UpdateOptions{})
 			if errUpdate != nil {
 				return errUpdate
 			}
+			service = updated
+		}
+		klog.V(4).Infof("Release Cloudflared Ingress %v for service %s on namespace %s ", ingresses, name, namespace)
+		if err == nil {
+			c.recorder.Eventf(service, v1.EventTypeNormal, "TunnelReady", "Releasing Cloudflare ingress for %s", name)
+		}
+		if c.dns != nil {
+			for _, i := range ingresses {
+				if derr := c.dns.EnsureLoadBalancerDeleted(context.TODO(), i.hostname); derr != nil {
+					if err == nil {
+						c.recorder.Eventf(service, v1.EventTypeWarning, "DNSFailed", "Error deleting CNAME record for %s: %v", i.hostname, derr)
+					}
+					return derr
+				}
+				if err == nil {
+					c.recorder.Eventf(service, v1.EventTypeNormal, "DNSReady", "CNAME %s removed", i.hostname)
+				}
+			}
+		}
+		c.deleteServicePorts(key)
+		c.configQueue.AddAfter(configKey, configDebounce)
+		if deleting && hasFinalizer(service) {
+			return c.removeFinalizer(service)
 		}
-		klog.Infof("Release Cloudflared Ingress %v for service %s on namespace %s ", ingress, name, namespace)
-		c.deleteService(key)
 		return nil
 	}
-	// service is LoadBalancer check if it already has associated an ingress
-	// This can happen after the controller restarts
-	for _, i := range service.Status.LoadBalancer.Ingress {
-		klog.Infof("Update IP %s for service %s on namespace %s ", i.Hostname, name, namespace)
-		c.addService(key, ingress{
-			hostname: i.Hostname,
-			// TODO: support multiport
-			service: net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(service.Spec.Ports[0].Port))),
-		})
-		return nil
+
+	// service is LoadBalancer and not being deleted: make sure it carries
+	// our finalizer before we create any external state for it, so a
+	// later deletion is guaranteed to come back through the branch above.
+	if !hasFinalizer(service) {
+		updated, err := c.addFinalizer(service)
+		if err != nil {
+			return err
+		}
+		service = updated
 	}
-	// assign a tunnel URI to the service
-	lbHostname := service.Name + "-" + service.Namespace
-	if len(c.config.Domain) > 0 {
 
+	// build one ingress per port and track them keyed by
+	// namespace/name/portName so multi-port services don't clobber each other
+	originRequest, err := originRequestConfig(service)
+	if err != nil {
+		return err
+	}
+	// This controller only ever runs a single cloudflared daemon, serving
+	// ingress for c.config.TunnelID. AnnotationTunnelID requesting any
+	// other tunnel can't be honored without a second daemon/config for
+	// that tunnel, and pointing the CNAME at a tunnel that never receives
+	// the matching ingress rule would actively break the service (a 1033
+	// at the edge) rather than leaving it alone. So the annotation is
+	// honored only when it agrees with c.config.TunnelID; any other value
+	// is ignored in favor of c.config.TunnelID, with a Warning Event
+	// explaining why.
+	tunnelID := c.config.TunnelID
+	if requested := tunnelIDOverride(service, c.config.TunnelID); requested != c.config.TunnelID {
+		c.recorder.Eventf(service, v1.EventTypeWarning, "TunnelMismatch",
+			"%s requests tunnel %s, but this controller only manages tunnel %s; ignoring the annotation",
+			AnnotationTunnelID, requested, c.config.TunnelID)
+	}
+	lbIngress := make([]v1.LoadBalancerIngress, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		host, overridden := hostnameOverride(service, port.Name)
+		if !overridden {
+			host = lbHostname(c.config, service, port.Name)
+		}
+		proto, overridden := protocolOverride(service)
+		if !overridden {
+			proto = serviceScheme(port.Name)
+		}
+		pKey := portKey(key, port.Name)
+		if c.dns != nil {
+			if err := c.dns.EnsureLoadBalancer(context.TODO(), host, tunnelID); err != nil {
+				c.recorder.Eventf(service, v1.EventTypeWarning, "DNSFailed", "Error creating CNAME record for %s: %v", host, err)
+				return err
+			}
+			c.recorder.Eventf(service, v1.EventTypeNormal, "DNSReady", "CNAME %s routed to tunnel %s", host, tunnelID)
+		}
+		c.addService(pKey, ingress{
+			hostname:      host,
+			service:       net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port))),
+			scheme:        proto,
+			originRequest: originRequest,
+		})
+		klog.V(4).Infof("Assign Hostname %s for service %s on namespace %s port %s", host, name, namespace, port.Name)
+		c.recorder.Eventf(service, v1.EventTypeNormal, "TunnelReady", "Cloudflare ingress %s -> %s ready", host, port.Name)
+		lbIngress = append(lbIngress, v1.LoadBalancerIngress{
+			Hostname: host,
+			Ports:    []v1.PortStatus{{Port: port.Port, Protocol: port.Protocol}},
+		})
+	}
+	service.Status.LoadBalancer.Ingress = lbIngress
+	setCondition(service, conditionTunnelReady, metav1.ConditionTrue, "IngressReady", "Cloudflare ingress rules are configured for all ports")
+	if c.dns != nil {
+		setCondition(service, conditionDNSReady, metav1.ConditionTrue, "RecordsReady", "CNAME records are routed to the tunnel")
+	}
+	if c.daemon != nil {
+		if c.daemon.Healthy() {
+			setCondition(service, conditionCloudflaredHealthy, metav1.ConditionTrue, "Running", "cloudflared daemon is healthy")
+		} else {
+			setCondition(service, conditionCloudflaredHealthy, metav1.ConditionFalse, "NotRunning", "cloudflared daemon is not healthy")
+		}
 	}
-	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: lbHostname}}
 	_, err = c.client.CoreV1().Services(namespace).UpdateStatus(context.TODO(), service, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
-	klog.Infof("Assign Hostname %s for service %s on namespace %s ", lbHostname, name, namespace)
-	c.addService(key, ingress{
-		hostname: lbHostname,
-		// TODO: support multiport
-		service: net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(service.Spec.Ports[0].Port))),
-	})
+	if c.daemon != nil {
+		reason, status := "CloudflaredUnhealthy", v1.EventTypeWarning
+		if c.daemon.Healthy() {
+			reason, status = "CloudflaredHealthy", v1.EventTypeNormal
+		}
+		c.recorder.Event(service, status, reason, "cloudflared daemon health")
+	}
+	c.configQueue.AddAfter(configKey, configDebounce)
 	return nil
 }
 
+// setCondition upserts a Status.Conditions entry on service, whose Type
+// matches the Reason of the Events reported through c.recorder so operators can inspect
+// reconciliation outcomes with `kubectl get svc -o yaml` as well as `kubectl
+// describe`.
+func setCondition(service *v1.Service, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&service.Status.Conditions, metav1.Condition{
+		Type:    condType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// hasFinalizer reports whether service carries finalizerName.
+func hasFinalizer(service *v1.Service) bool {
+	for _, f := range service.Finalizers {
+		if f == finalizerName {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds finalizerName to service so its deletion is blocked
+// until syncServices has released its Cloudflare ingress and DNS record. It
+// returns the object returned by the Update call, since that call advances
+// the server-side ResourceVersion and callers must use it (not the stale
+// pre-Update object) for any further update to the same Service.
+func (c *Controller) addFinalizer(service *v1.Service) (*v1.Service, error) {
+	service.Finalizers = append(service.Finalizers, finalizerName)
+	return c.client.CoreV1().Services(service.Namespace).Update(context.TODO(), service, metav1.UpdateOptions{})
+}
+
+// removeFinalizer drops finalizerName from service, letting the API server
+// finish deleting it.
+func (c *Controller) removeFinalizer(service *v1.Service) error {
+	finalizers := make([]string, 0, len(service.Finalizers))
+	for _, f := range service.Finalizers {
+		if f != finalizerName {
+			finalizers = append(finalizers, f)
+		}
+	}
+	service.Finalizers = finalizers
+	_, err := c.client.CoreV1().Services(service.Namespace).Update(context.TODO(), service, metav1.UpdateOptions{})
+	return err
+}
+
 // handlers
 
 // onServiceUpdate queues the Service for processing.
@@ -231,9 +505,9 @@ func (c *Controller) onServiceUpdate(oldObj, newObj interface{}) {
 	oldService := oldObj.(*v1.Service)
 	newService := newObj.(*v1.Service)
 
-	// don't process resync or objects that are marked for deletion
-	if oldService.ResourceVersion == newService.ResourceVersion ||
-		!newService.GetDeletionTimestamp().IsZero() {
+	// don't process resyncs; deletion-marked objects still need a sync so
+	// their finalizer can be removed once cleanup is done
+	if oldService.ResourceVersion == newService.ResourceVersion {
 		return
 	}
 
@@ -255,14 +529,29 @@ func (c *Controller) onServiceDelete(obj interface{}) {
 }
 
 // service tracker
+//
+// Entries are keyed per-port, since a Service of type LoadBalancer can expose
+// more than one port and each one gets its own Cloudflare ingress rule.
 
 // ref: https://developers.cloudflare.com/cloudflare-one/connections/connect-apps/configuration/configuration-file/ingress
 type ingress struct {
-	hostname string // public URI (hostname)
-	service  string // internal service URI hostname:port
+	hostname      string // public URI (hostname)
+	service       string // internal service URI hostname:port
+	scheme        string // service scheme: http, https, tcp, ssh, rdp
+	originRequest cloudflaredconfig.OriginRequestConfig
+}
+
+// portKey builds the serviceTracker key for a given Service key (namespace/name)
+// and port name. Unnamed ports (single-port Services) are tracked under the
+// bare Service key.
+func portKey(key, portName string) string {
+	if portName == "" {
+		return key
+	}
+	return key + "/" + portName
 }
 
-// add or update service
+// add or update a single port ingress for a service
 func (c *Controller) addService(key string, ingress ingress) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -270,28 +559,147 @@ func (c *Controller) addService(key string, ingress ingress) {
 	return
 }
 
-func (c *Controller) getServiceIngress(key string) ingress {
+// getServiceIngresses returns all the port ingresses tracked for the given
+// service key.
+func (c *Controller) getServiceIngresses(key string) map[string]ingress {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.serviceTracker[key]
+	out := map[string]ingress{}
+	for k, v := range c.serviceTracker {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			out[k] = v
+		}
+	}
+	return out
 }
 
-func (c *Controller) deleteService(key string) {
+// deleteServicePorts removes all the port ingresses tracked for the given
+// service key.
+func (c *Controller) deleteServicePorts(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.serviceTracker, key)
+	for k := range c.serviceTracker {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(c.serviceTracker, k)
+		}
+	}
+}
+
+// seedInitialSync records every service key present in the already-synced
+// informer cache as pending, so initialSyncComplete reports false until each
+// one has gone through syncServices at least once.
+func (c *Controller) seedInitialSync() {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("listing services for initial sync: %v", err))
+		return
+	}
+	c.initialSyncMu.Lock()
+	defer c.initialSyncMu.Unlock()
+	c.pendingInitialSync = make(map[string]struct{}, len(services))
+	for _, svc := range services {
+		key, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil {
+			continue
+		}
+		c.pendingInitialSync[key] = struct{}{}
+	}
+}
 
+// markInitialSyncDone removes key from the pending initial sync set.
+func (c *Controller) markInitialSyncDone(key string) {
+	c.initialSyncMu.Lock()
+	defer c.initialSyncMu.Unlock()
+	delete(c.pendingInitialSync, key)
 }
 
-// get the tracker map
-func (c *Controller) writeConfig() {
+// initialSyncComplete reports whether every service seen in the informer
+// cache's initial list has gone through syncServices at least once.
+func (c *Controller) initialSyncComplete() bool {
+	c.initialSyncMu.Lock()
+	defer c.initialSyncMu.Unlock()
+	return len(c.pendingInitialSync) == 0
+}
+
+// desiredHostnames returns the tunnelID each currently tracked hostname
+// should resolve to, used as the desired state when reconciling DNS records.
+func (c *Controller) desiredHostnames() map[string]string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	config := cloudflared.NewFromConfig(c.config)
+	desired := make(map[string]string, len(c.serviceTracker))
+	for _, i := range c.serviceTracker {
+		desired[i.hostname] = c.config.TunnelID
+	}
+	return desired
+}
+
+// reconcileDNS diffs the tracked hostnames against the Cloudflare zone and
+// removes stale CNAME records. It skips the pass until every service seen in
+// the informer cache's initial list has synced at least once, so it doesn't
+// delete records for services that haven't landed in serviceTracker yet.
+func (c *Controller) reconcileDNS() {
+	if !c.initialSyncComplete() {
+		return
+	}
+	if err := c.dns.Reconcile(context.Background(), c.desiredHostnames()); err != nil {
+		klog.Errorf("Error reconciling Cloudflare DNS records: %v", err)
+	}
+}
 
-	// Copy from the original map to the target map
+// writeConfig renders config.yaml from the tracker map and, if its content
+// actually changed, asks the supervised cloudflared daemon to hot-reload
+// its ingress configuration instead of restarting.
+func (c *Controller) writeConfig() {
+	c.mu.Lock()
+	cfg := cloudflared.NewFromConfig(c.config)
 	for _, ingress := range c.serviceTracker {
-		config.AddIngress(ingress.hostname, ingress.service)
+		cfg.AddIngress(ingress.hostname, ingress.scheme+"://"+ingress.service, ingress.originRequest)
+	}
+	c.mu.Unlock()
+
+	changed, err := cfg.Write()
+	if err != nil {
+		klog.Errorf("Error writing cloudflared configuration: %v", err)
+		return
+	}
+	if !changed || c.daemon == nil {
+		return
+	}
+	if err := c.daemon.Reload(); err != nil {
+		klog.Errorf("Error reloading cloudflared: %v", err)
+	}
+}
+
+// lbHostname builds the public hostname for a service port, following the
+// <service>-[<port-name>-]<namespace>.<domain> scheme documented above.
+func lbHostname(config config.Config, service *v1.Service, portName string) string {
+	name := service.Name
+	if portName != "" {
+		name = name + "-" + portName
+	}
+	host := name + "-" + service.Namespace
+	if len(config.Domain) > 0 {
+		host = host + "." + config.Domain
+	}
+	return host
+}
+
+// serviceScheme picks the Cloudflared origin scheme for a port based on its
+// name, since Cloudflared's ingress config distinguishes http/https/tcp/ssh/rdp
+// origins. Ports are named following the Kubernetes convention (e.g. "https",
+// "web-ssh"), defaulting to "http" when nothing more specific matches.
+func serviceScheme(portName string) string {
+	portName = strings.ToLower(portName)
+	switch {
+	case strings.Contains(portName, "https"):
+		return "https"
+	case strings.Contains(portName, "ssh"):
+		return "ssh"
+	case strings.Contains(portName, "rdp"):
+		return "rdp"
+	case strings.Contains(portName, "tcp"):
+		return "tcp"
+	default:
+		return "http"
 	}
-	config.Write()
 }
@@ -0,0 +1,98 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	"github.com/aojea/kloudflarelb/pkg/config"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLbHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config.Config
+		service  *v1.Service
+		portName string
+		want     string
+	}{
+		{
+			name:   "single port, no domain",
+			config: config.Config{},
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			},
+			portName: "",
+			want:     "web-default",
+		},
+		{
+			name:   "single port, with domain",
+			config: config.Config{Domain: "example.com"},
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			},
+			portName: "",
+			want:     "web-default.example.com",
+		},
+		{
+			name:   "named port is folded into the hostname",
+			config: config.Config{Domain: "example.com"},
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod"},
+			},
+			portName: "https",
+			want:     "web-https-prod.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lbHostname(tt.config, tt.service, tt.portName); got != tt.want {
+				t.Errorf("lbHostname() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceScheme(t *testing.T) {
+	tests := []struct {
+		portName string
+		want     string
+	}{
+		{"", "http"},
+		{"http", "http"},
+		{"https", "https"},
+		{"web-https", "https"},
+		{"HTTPS", "https"},
+		{"ssh", "ssh"},
+		{"web-ssh", "ssh"},
+		{"rdp", "rdp"},
+		{"tcp", "tcp"},
+		{"metrics-tcp", "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.portName, func(t *testing.T) {
+			if got := serviceScheme(tt.portName); got != tt.want {
+				t.Errorf("serviceScheme(%q) = %q, want %q", tt.portName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		portName string
+		want     string
+	}{
+		{"default/web", "", "default/web"},
+		{"default/web", "https", "default/web/https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"/"+tt.portName, func(t *testing.T) {
+			if got := portKey(tt.key, tt.portName); got != tt.want {
+				t.Errorf("portKey(%q, %q) = %q, want %q", tt.key, tt.portName, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package loadbalancer
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// queueDepth tracks how many services are currently waiting to be
+	// reconciled, so operators can see a growing backlog before it turns
+	// into stale DNS records or ingress rules.
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kloudflarelb_queue_depth",
+		Help: "Current depth of the service reconcile workqueue.",
+	})
+	// reconcileDuration tracks how long a single syncServices call takes,
+	// including the Cloudflare API calls it makes.
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kloudflarelb_reconcile_duration_seconds",
+		Help:    "Time it took to reconcile a single service.",
+		Buckets: prometheus.DefBuckets,
+	})
+	// reconcileRetriesTotal counts how many times a service sync was
+	// requeued after failing, a proxy for Cloudflare API or apiserver
+	// flakiness.
+	reconcileRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kloudflarelb_reconcile_retries_total",
+		Help: "Total number of service reconciles requeued after an error.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, reconcileDuration, reconcileRetriesTotal)
+}